@@ -1,16 +1,39 @@
 package git
 
 /*
-#cgo pkg-config: libgit2
+#cgo pkg-config: libgit2 >= 0.28
 #include <git2.h>
 #include <git2/errors.h>
+#include <git2/version.h>
+
+#if LIBGIT2_VER_MAJOR == 0 && LIBGIT2_VER_MINOR < 28
+#error "this package requires libgit2 >= 0.28: git_config_add_file_ondisk and git_config_open's backend vtable gained their git_repository parameter in 0.28, and AddFileForRepo/AddBackend rely on it"
+#endif
 */
 import "C"
 import (
+	"errors"
 	"runtime"
 	"unsafe"
 )
 
+// ErrIterOver is returned by ConfigIterator.Next once every entry has
+// been visited.
+var ErrIterOver = errors.New("config iteration is over")
+
+// ErrNotFound is returned by the Lookup* functions when name is not set,
+// letting callers tell a missing key apart from any other failure with
+// errors.Is instead of string-matching LastError().
+var ErrNotFound = errors.New("config value does not exist")
+
+func configLookupError(ret C.int) error {
+	if ret == C.GIT_ENOTFOUND {
+		return ErrNotFound
+	}
+
+	return LastError()
+}
+
 type ConfigLevel int
 
 const (
@@ -55,10 +78,29 @@ func NewConfig() (*Config, error) {
 
 // AddFile adds a file-backed backend to the config object at the specified level.
 func (c *Config) AddFile(path string, level ConfigLevel, force bool) error {
+	return c.addFileOndisk(path, level, nil, force)
+}
+
+// AddFileForRepo adds a file-backed backend to the config object at the
+// specified level, resolving the file against repo. This allows
+// conditional includes ([includeIf "gitdir:..."], [includeIf
+// "onbranch:..."]) in the added file to be evaluated the same way the
+// git command line evaluates them; AddFile alone cannot honor them since
+// it has no repository to match against.
+func (c *Config) AddFileForRepo(path string, level ConfigLevel, repo *Repository, force bool) error {
+	return c.addFileOndisk(path, level, repo, force)
+}
+
+func (c *Config) addFileOndisk(path string, level ConfigLevel, repo *Repository, force bool) error {
 	cpath := C.CString(path)
 	defer C.free(unsafe.Pointer(cpath))
 
-	ret := C.git_config_add_file_ondisk(c.ptr, cpath, C.git_config_level_t(level), cbool(force))
+	var crepo *C.git_repository
+	if repo != nil {
+		crepo = repo.ptr
+	}
+
+	ret := C.git_config_add_file_ondisk(c.ptr, cpath, C.git_config_level_t(level), crepo, cbool(force))
 	if ret < 0 {
 		return LastError()
 	}
@@ -73,7 +115,7 @@ func (c *Config) LookupInt32(name string) (int32, error) {
 
 	ret := C.git_config_get_int32(&out, c.ptr, cname)
 	if ret < 0 {
-		return 0, LastError()
+		return 0, configLookupError(ret)
 	}
 
 	return int32(out), nil
@@ -86,12 +128,23 @@ func (c *Config) LookupInt64(name string) (int64, error) {
 
 	ret := C.git_config_get_int64(&out, c.ptr, cname)
 	if ret < 0 {
-		return 0, LastError()
+		return 0, configLookupError(ret)
 	}
 
 	return int64(out), nil
 }
 
+// GetInt64Default returns the int64 value of name, or def if name is not
+// set or its value is not a valid integer.
+func (c *Config) GetInt64Default(name string, def int64) int64 {
+	value, err := c.LookupInt64(name)
+	if err != nil {
+		return def
+	}
+
+	return value
+}
+
 func (c *Config) LookupString(name string) (string, error) {
 	var ptr *C.char
 	cname := C.CString(name)
@@ -99,18 +152,57 @@ func (c *Config) LookupString(name string) (string, error) {
 
 	ret := C.git_config_get_string(&ptr, c.ptr, cname)
 	if ret < 0 {
-		return "", LastError()
+		return "", configLookupError(ret)
 	}
 
 	return C.GoString(ptr), nil
 }
 
+// GetStringDefault returns the string value of name, or def if name is
+// not set.
+func (c *Config) GetStringDefault(name, def string) string {
+	value, err := c.LookupString(name)
+	if err != nil {
+		return def
+	}
+
+	return value
+}
+
 func (c *Config) LookupBool(name string) (bool, error) {
 	var out C.int
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
 
 	ret := C.git_config_get_bool(&out, c.ptr, cname)
+	if ret < 0 {
+		return false, configLookupError(ret)
+	}
+
+	return gobool(out), nil
+}
+
+// GetBoolDefault returns the bool value of name, or def if name is not
+// set or its value is not a valid boolean.
+func (c *Config) GetBoolDefault(name string, def bool) bool {
+	value, err := c.LookupBool(name)
+	if err != nil {
+		return def
+	}
+
+	return value
+}
+
+// ParseBool interprets value the way git itself parses a boolean
+// configuration value ("true"/"yes"/"on"/"1" and their opposites, among
+// others), so that values obtained from outside of Config (environment
+// variables, CLI flags) can be parsed with the same rules.
+func (c *Config) ParseBool(value string) (bool, error) {
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	var out C.int
+	ret := C.git_config_parse_bool(&out, cvalue)
 	if ret < 0 {
 		return false, LastError()
 	}
@@ -118,6 +210,23 @@ func (c *Config) LookupBool(name string) (bool, error) {
 	return gobool(out), nil
 }
 
+// ParsePath applies git's config path parsing rules to value, expanding
+// a leading "~/" to the current user's home directory.
+func (c *Config) ParsePath(value string) (string, error) {
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	var buf C.git_buf
+	defer C.git_buf_dispose(&buf)
+
+	ret := C.git_config_parse_path(&buf, cvalue)
+	if ret < 0 {
+		return "", LastError()
+	}
+
+	return C.GoString(buf.ptr), nil
+}
+
 func (c *Config) SetString(name, value string) (err error) {
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
@@ -200,6 +309,22 @@ func (c *Config) Delete(name string) error {
 	return nil
 }
 
+// Snapshot creates a read-only copy of the configuration as it is at the
+// moment of the call. Lookups against the snapshot are guaranteed to
+// remain consistent for its lifetime, even if the original Config is
+// later refreshed or mutated by another writer, making it the safe way
+// to perform several related Lookup* calls.
+func (c *Config) Snapshot() (*Config, error) {
+	config := new(Config)
+
+	ret := C.git_config_snapshot(&config.ptr, c.ptr)
+	if ret < 0 {
+		return nil, LastError()
+	}
+
+	return config, nil
+}
+
 // OpenLevel creates a single-level focused config object from a multi-level one
 func (c *Config) OpenLevel(parent *Config, level ConfigLevel) (*Config, error) {
 	config := new(Config)
@@ -211,20 +336,204 @@ func (c *Config) OpenLevel(parent *Config, level ConfigLevel) (*Config, error) {
 	return config, nil
 }
 
-// OpenOndisk creates a new config instance containing a single on-disk file
+// OpenOndisk creates a new config instance containing a single on-disk
+// file. parent is unused (kept only for signature compatibility with
+// earlier versions of this package). To add a conditional-include-aware
+// file to an existing config, use Config.AddFileForRepo; to open one on
+// its own, use OpenOndiskForRepo.
 func OpenOndisk(parent *Config, path string) (*Config, error) {
+	return openOndisk(path, nil)
+}
+
+// OpenOndiskForRepo creates a new config instance containing a single
+// on-disk file, resolving any conditional includes in it against repo.
+func OpenOndiskForRepo(path string, repo *Repository) (*Config, error) {
+	return openOndisk(path, repo)
+}
+
+// openOndisk builds the instance itself rather than calling
+// git_config_open_ondisk, since that function has no repo parameter in
+// any released libgit2 - only git_config_add_file_ondisk gained one, to
+// support resolving conditional includes.
+func openOndisk(path string, repo *Repository) (*Config, error) {
 	cpath := C.CString(path)
 	defer C.free(unsafe.Pointer(cpath))
 
+	var crepo *C.git_repository
+	if repo != nil {
+		crepo = repo.ptr
+	}
+
 	config := new(Config)
-	ret := C.git_config_open_ondisk(&config.ptr, cpath)
+	ret := C.git_config_new(&config.ptr)
 	if ret < 0 {
 		return nil, LastError()
 	}
 
+	ret = C.git_config_add_file_ondisk(config.ptr, cpath, C.GIT_CONFIG_LEVEL_LOCAL, crepo, 0)
+	if ret < 0 {
+		C.git_config_free(config.ptr)
+		return nil, LastError()
+	}
+
 	return config, nil
 }
 
+// ConfigEntry represents a single entry in a configuration file, together
+// with the level it was read from.
+type ConfigEntry struct {
+	Name  string
+	Value string
+	Level ConfigLevel
+}
+
+func configEntryFromC(entry *C.git_config_entry) *ConfigEntry {
+	return &ConfigEntry{
+		Name:  C.GoString(entry.name),
+		Value: C.GoString(entry.value),
+		Level: ConfigLevel(entry.level),
+	}
+}
+
+// ConfigIterator enumerates the entries of a Config, as returned by
+// Config.NewIterator and Config.NewIteratorGlob.
+type ConfigIterator struct {
+	ptr *C.git_config_iterator
+}
+
+// NewIterator creates an iterator over every entry in the configuration,
+// across all levels and including multivars.
+func (c *Config) NewIterator() (*ConfigIterator, error) {
+	i := new(ConfigIterator)
+
+	ret := C.git_config_iterator_new(&i.ptr, c.ptr)
+	if ret < 0 {
+		return nil, LastError()
+	}
+
+	return i, nil
+}
+
+// NewIteratorGlob creates an iterator over the entries whose name matches
+// regexp.
+func (c *Config) NewIteratorGlob(regexp string) (*ConfigIterator, error) {
+	cregexp := C.CString(regexp)
+	defer C.free(unsafe.Pointer(cregexp))
+
+	i := new(ConfigIterator)
+
+	ret := C.git_config_iterator_glob_new(&i.ptr, c.ptr, cregexp)
+	if ret < 0 {
+		return nil, LastError()
+	}
+
+	return i, nil
+}
+
+// Next advances the iterator and returns the entry it now points to. It
+// returns ErrIterOver once the iterator is exhausted.
+func (i *ConfigIterator) Next() (*ConfigEntry, error) {
+	var entry *C.git_config_entry
+
+	ret := C.git_config_next(&entry, i.ptr)
+	if ret < 0 {
+		if ret == C.GIT_ITEROVER {
+			return nil, ErrIterOver
+		}
+		return nil, LastError()
+	}
+
+	return configEntryFromC(entry), nil
+}
+
+// Free releases the resources backing the iterator.
+func (i *ConfigIterator) Free() {
+	runtime.SetFinalizer(i, nil)
+	C.git_config_iterator_free(i.ptr)
+}
+
+// Foreach calls fn once for every entry in the configuration. Iteration
+// stops and the error is returned as soon as fn returns a non-nil error.
+func (c *Config) Foreach(fn func(*ConfigEntry) error) error {
+	iter, err := c.NewIterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Free()
+
+	return iterateConfig(iter, fn)
+}
+
+// ForeachMatch calls fn once for every entry whose name matches regexp.
+// Iteration stops and the error is returned as soon as fn returns a
+// non-nil error.
+func (c *Config) ForeachMatch(regexp string, fn func(*ConfigEntry) error) error {
+	iter, err := c.NewIteratorGlob(regexp)
+	if err != nil {
+		return err
+	}
+	defer iter.Free()
+
+	return iterateConfig(iter, fn)
+}
+
+func iterateConfig(iter *ConfigIterator, fn func(*ConfigEntry) error) error {
+	for {
+		entry, err := iter.Next()
+		if err != nil {
+			if err == ErrIterOver {
+				return nil
+			}
+			return err
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// LookupMultivar returns every value set for name whose value matches
+// regexp. Pass an empty regexp to return all of the values set for name.
+// It returns ErrNotFound if no value matches.
+func (c *Config) LookupMultivar(name, regexp string) ([]string, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	cregexp := C.CString(regexp)
+	defer C.free(unsafe.Pointer(cregexp))
+
+	var ptr *C.git_config_iterator
+	ret := C.git_config_multivar_iterator_new(&ptr, c.ptr, cname, cregexp)
+	if ret < 0 {
+		return nil, configLookupError(ret)
+	}
+
+	iter := &ConfigIterator{ptr: ptr}
+	defer iter.Free()
+
+	var values []string
+	for {
+		entry, err := iter.Next()
+		if err != nil {
+			if err == ErrIterOver {
+				break
+			}
+			return nil, err
+		}
+
+		values = append(values, entry.Value)
+	}
+
+	// git_config_multivar_iterator_new does not report a missing name
+	// itself; an iteration that yields nothing means nothing matched.
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return values, nil
+}
+
 // Refresh refreshes the configuration to reflect any changes made externally e.g. on disk
 func (c *Config) Refresh() error {
 	ret := C.git_config_refresh(c.ptr)
@@ -0,0 +1,339 @@
+package git
+
+/*
+#cgo pkg-config: libgit2 >= 0.28
+#include <git2.h>
+#include <git2/sys/config.h>
+#include <git2/version.h>
+#include "config_backend.h"
+
+#if LIBGIT2_VER_MAJOR == 0 && LIBGIT2_VER_MINOR < 28
+#error "this package requires libgit2 >= 0.28: git_config_add_backend and the open(cb, level, repo) vtable slot it dispatches through were introduced in 0.28"
+#endif
+*/
+import "C"
+
+import (
+	"errors"
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+)
+
+// ConfigBackend is the interface a Go type must implement to be usable
+// as a git_config_backend via Config.AddBackend. It mirrors the vtable
+// libgit2 exposes through sys/config.h, and lets an application back git
+// configuration with something other than an on-disk INI file, e.g. an
+// in-memory map for tests, or values sourced from another service.
+type ConfigBackend interface {
+	// Open is called once the backend has been added to a Config, to let
+	// it load its data for the given level.
+	Open(level ConfigLevel) error
+
+	// Get returns the value currently stored for name. It must return
+	// ErrNotFound if name is not set.
+	Get(name string) (string, error)
+
+	// Set stores value for name, overwriting any existing value.
+	Set(name, value string) error
+
+	// Del removes name. It must return ErrNotFound if name is not set.
+	Del(name string) error
+
+	// Iterator returns every entry currently stored in the backend.
+	Iterator() ([]*ConfigEntry, error)
+
+	// Lock prevents concurrent writers from modifying the backend until
+	// Unlock is called.
+	Lock() error
+
+	// Unlock releases a lock taken by Lock. success reports whether the
+	// change the lock was guarding should be committed.
+	Unlock(success bool) error
+
+	// Snapshot returns a backend representing a frozen, read-only copy
+	// of the current state.
+	Snapshot() (ConfigBackend, error)
+
+	// Free releases any resources held by the backend.
+	Free()
+}
+
+// configBackendHandle is what the cgo.Handle passed through
+// go_config_backend actually points at (go_config_iterator's handle
+// points at a configIteratorState, which refers back to one): the
+// ConfigBackend itself, plus the bookkeeping the C shim needs but that
+// ConfigBackend implementations shouldn't have to care about - the level
+// the backend was registered at (Get has no other way to report it on
+// its returned git_config_entry), and every git_config_entry the shim
+// has handed to libgit2. Neither git_config_get_string nor
+// git_config_next take ownership of the entry they're handed on older
+// libgit2, so the entries must outlive the call and be freed once,
+// explicitly, when the backend itself goes away.
+type configBackendHandle struct {
+	backend ConfigBackend
+	level   ConfigLevel
+
+	mu      sync.Mutex
+	entries []*C.git_config_entry
+}
+
+func (h *configBackendHandle) newEntry(cname, cvalue *C.char) *C.git_config_entry {
+	entry := C.go_config_entry_new(cname, cvalue, C.git_config_level_t(h.level))
+	if entry == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	h.entries = append(h.entries, entry)
+	h.mu.Unlock()
+
+	return entry
+}
+
+func (h *configBackendHandle) freeEntries() {
+	h.mu.Lock()
+	entries := h.entries
+	h.entries = nil
+	h.mu.Unlock()
+
+	for _, entry := range entries {
+		C.go_config_entry_free(entry)
+	}
+}
+
+// AddBackend registers backend with the configuration at the given
+// level, as if it were a native libgit2 config backend.
+func (c *Config) AddBackend(backend ConfigBackend, level ConfigLevel, force bool) error {
+	h := cgo.NewHandle(&configBackendHandle{backend: backend, level: level})
+
+	cbackend := C.go_git_config_backend_new(C.uintptr_t(h))
+	if cbackend == nil {
+		h.Delete()
+		return errors.New("failed to allocate config backend")
+	}
+
+	ret := C.git_config_add_backend(c.ptr, cbackend, C.git_config_level_t(level), nil, cbool(force))
+	if ret < 0 {
+		// libgit2 does not take ownership of cbackend on failure, so free
+		// it ourselves; go_git_config_backend_free calls its ->free,
+		// which in turn runs goConfigBackendFree and deletes h.
+		C.go_git_config_backend_free(cbackend)
+		return LastError()
+	}
+
+	return nil
+}
+
+func configBackendFromHandle(handle C.uintptr_t) (*configBackendHandle, bool) {
+	h, ok := cgo.Handle(handle).Value().(*configBackendHandle)
+	return h, ok
+}
+
+//export goConfigBackendOpen
+func goConfigBackendOpen(handle C.uintptr_t, level C.git_config_level_t) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	// libgit2 opens a backend at the level it was added at (the level
+	// AddBackend was given, or the original's level for a snapshot);
+	// record it for the entries we report back through Get/Iterator.
+	h.level = ConfigLevel(level)
+
+	if err := h.backend.Open(h.level); err != nil {
+		return -1
+	}
+
+	return 0
+}
+
+//export goConfigBackendGet
+func goConfigBackendGet(handle C.uintptr_t, ckey *C.char, out **C.git_config_entry) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	value, err := h.backend.Get(C.GoString(ckey))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return C.GIT_ENOTFOUND
+		}
+		return -1
+	}
+
+	cvalue := C.CString(value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	// Owned by h, and freed when the backend itself is freed: older
+	// libgit2's get path borrows entry->value out of this struct and
+	// never calls entry->free.
+	entry := h.newEntry(ckey, cvalue)
+	if entry == nil {
+		return -1
+	}
+
+	*out = entry
+	return 0
+}
+
+//export goConfigBackendSet
+func goConfigBackendSet(handle C.uintptr_t, ckey, cvalue *C.char) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	if err := h.backend.Set(C.GoString(ckey), C.GoString(cvalue)); err != nil {
+		return -1
+	}
+
+	return 0
+}
+
+//export goConfigBackendDel
+func goConfigBackendDel(handle C.uintptr_t, ckey *C.char) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	if err := h.backend.Del(C.GoString(ckey)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return C.GIT_ENOTFOUND
+		}
+		return -1
+	}
+
+	return 0
+}
+
+//export goConfigBackendLock
+func goConfigBackendLock(handle C.uintptr_t) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	if err := h.backend.Lock(); err != nil {
+		return -1
+	}
+
+	return 0
+}
+
+//export goConfigBackendUnlock
+func goConfigBackendUnlock(handle C.uintptr_t, success C.int) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	if err := h.backend.Unlock(success != 0); err != nil {
+		return -1
+	}
+
+	return 0
+}
+
+//export goConfigBackendSnapshot
+func goConfigBackendSnapshot(handle C.uintptr_t, out **C.git_config_backend) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	snap, err := h.backend.Snapshot()
+	if err != nil {
+		return -1
+	}
+
+	snapHandle := cgo.NewHandle(&configBackendHandle{backend: snap, level: h.level})
+	cbackend := C.go_git_config_backend_new(C.uintptr_t(snapHandle))
+	if cbackend == nil {
+		snapHandle.Delete()
+		return -1
+	}
+
+	*out = cbackend
+	return 0
+}
+
+//export goConfigBackendFree
+func goConfigBackendFree(handle C.uintptr_t) {
+	if h, ok := configBackendFromHandle(handle); ok {
+		h.backend.Free()
+		h.freeEntries()
+	}
+	cgo.Handle(handle).Delete()
+}
+
+// configIteratorState is the Go-side cursor behind a go_config_iterator,
+// walking the slice a ConfigBackend.Iterator call returned. It shares
+// its backend's configBackendHandle so every git_config_entry it
+// allocates is freed alongside the backend's own, rather than leaking
+// for the iterator's (typically much shorter) lifetime.
+type configIteratorState struct {
+	backend *configBackendHandle
+	entries []*ConfigEntry
+	index   int
+}
+
+//export goConfigBackendIterator
+func goConfigBackendIterator(handle C.uintptr_t, out **C.git_config_iterator) C.int {
+	h, ok := configBackendFromHandle(handle)
+	if !ok {
+		return -1
+	}
+
+	entries, err := h.backend.Iterator()
+	if err != nil {
+		return -1
+	}
+
+	iterHandle := cgo.NewHandle(&configIteratorState{backend: h, entries: entries})
+
+	iter := C.go_git_config_iterator_new(C.uintptr_t(iterHandle))
+	if iter == nil {
+		iterHandle.Delete()
+		return -1
+	}
+
+	*out = iter
+	return 0
+}
+
+//export goConfigIteratorNext
+func goConfigIteratorNext(handle C.uintptr_t, out **C.git_config_entry) C.int {
+	state, ok := cgo.Handle(handle).Value().(*configIteratorState)
+	if !ok {
+		return -1
+	}
+
+	if state.index >= len(state.entries) {
+		return C.GIT_ITEROVER
+	}
+
+	entry := state.entries[state.index]
+	state.index++
+
+	cname := C.CString(entry.Name)
+	defer C.free(unsafe.Pointer(cname))
+	cvalue := C.CString(entry.Value)
+	defer C.free(unsafe.Pointer(cvalue))
+
+	centry := state.backend.newEntry(cname, cvalue)
+	if centry == nil {
+		return -1
+	}
+
+	*out = centry
+	return 0
+}
+
+//export goConfigIteratorFree
+func goConfigIteratorFree(handle C.uintptr_t) {
+	cgo.Handle(handle).Delete()
+}
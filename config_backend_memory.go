@@ -0,0 +1,111 @@
+package git
+
+import "sync"
+
+// MemoryConfigBackend is a ConfigBackend that stores its entries purely
+// in memory, with no on-disk file behind it. It is primarily useful for
+// tests that want to layer application-supplied values above
+// ConfigLevelApp without writing a temporary ini file, but is also a
+// reference for implementing other ConfigBackend-backed stores (Consul,
+// etcd, values injected from a parent process, ...).
+type MemoryConfigBackend struct {
+	mu      sync.Mutex
+	entries map[string]string
+	level   ConfigLevel
+
+	// txMu serializes Lock/Unlock transactions. It is deliberately kept
+	// separate from mu: libgit2 drives a transaction as Lock, then one
+	// or more Get/Set/Del calls, then Unlock, all on the same goroutine,
+	// and mu is not reentrant - reusing it here would deadlock as soon
+	// as a write happened inside the lock.
+	txMu sync.Mutex
+}
+
+// NewMemoryConfigBackend creates an empty in-memory configuration
+// backend.
+func NewMemoryConfigBackend() *MemoryConfigBackend {
+	return &MemoryConfigBackend{entries: make(map[string]string)}
+}
+
+// Open records the level this backend was registered at, so Iterator
+// can report it on each ConfigEntry.
+func (b *MemoryConfigBackend) Open(level ConfigLevel) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.level = level
+	return nil
+}
+
+func (b *MemoryConfigBackend) Get(name string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	value, ok := b.entries[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return value, nil
+}
+
+func (b *MemoryConfigBackend) Set(name, value string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[name] = value
+	return nil
+}
+
+func (b *MemoryConfigBackend) Del(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.entries[name]; !ok {
+		return ErrNotFound
+	}
+
+	delete(b.entries, name)
+	return nil
+}
+
+func (b *MemoryConfigBackend) Iterator() ([]*ConfigEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := make([]*ConfigEntry, 0, len(b.entries))
+	for name, value := range b.entries {
+		entries = append(entries, &ConfigEntry{Name: name, Value: value, Level: b.level})
+	}
+
+	return entries, nil
+}
+
+// Lock takes the transaction lock, so that a second Lock waits until
+// Unlock. Get, Set and Del do not take it: writes from other goroutines
+// are not blocked while it is held.
+func (b *MemoryConfigBackend) Lock() error {
+	b.txMu.Lock()
+	return nil
+}
+
+// Unlock releases the transaction lock. Writes made while it was held
+// were applied as they happened and stay applied; success is ignored.
+func (b *MemoryConfigBackend) Unlock(success bool) error {
+	b.txMu.Unlock()
+	return nil
+}
+
+func (b *MemoryConfigBackend) Snapshot() (ConfigBackend, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := make(map[string]string, len(b.entries))
+	for name, value := range b.entries {
+		entries[name] = value
+	}
+
+	return &MemoryConfigBackend{entries: entries, level: b.level}, nil
+}
+
+func (b *MemoryConfigBackend) Free() {}
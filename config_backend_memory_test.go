@@ -0,0 +1,78 @@
+package git
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryConfigBackendLayering(t *testing.T) {
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	defer cfg.Free()
+
+	if err := cfg.AddBackend(NewMemoryConfigBackend(), ConfigLevelApp, false); err != nil {
+		t.Fatalf("AddBackend: %v", err)
+	}
+
+	if err := cfg.SetString("test.value", "hello"); err != nil {
+		t.Fatalf("SetString: %v", err)
+	}
+
+	value, err := cfg.LookupString("test.value")
+	if err != nil {
+		t.Fatalf("LookupString: %v", err)
+	}
+	if value != "hello" {
+		t.Fatalf("LookupString = %q, want %q", value, "hello")
+	}
+
+	var names []string
+	err = cfg.Foreach(func(entry *ConfigEntry) error {
+		names = append(names, entry.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Foreach: %v", err)
+	}
+	if len(names) != 1 || names[0] != "test.value" {
+		t.Fatalf("Foreach saw %v, want [test.value]", names)
+	}
+
+	if err := cfg.Delete("test.value"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := cfg.LookupString("test.value"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("LookupString after delete = %v, want ErrNotFound", err)
+	}
+}
+
+// TestMemoryConfigBackendLockUnlockDoesNotDeadlock exercises the
+// Lock/Set/Unlock sequence libgit2 drives on a single goroutine when
+// committing a config transaction. Lock and the data mutex used by Set
+// must not be the same lock, or this hangs forever.
+func TestMemoryConfigBackendLockUnlockDoesNotDeadlock(t *testing.T) {
+	backend := NewMemoryConfigBackend()
+
+	if err := backend.Lock(); err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	if err := backend.Set("test.value", "hello"); err != nil {
+		t.Fatalf("Set while locked: %v", err)
+	}
+
+	if err := backend.Unlock(true); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+
+	value, err := backend.Get("test.value")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if value != "hello" {
+		t.Fatalf("Get = %q, want %q", value, "hello")
+	}
+}
@@ -0,0 +1,24 @@
+package git
+
+/*
+#cgo pkg-config: libgit2
+#include <git2.h>
+*/
+import "C"
+
+// Config opens the repository's combined configuration, merging the
+// repository-local, global, XDG and system levels in the usual order of
+// precedence. Because the returned Config is opened through the
+// repository, any [includeIf "gitdir:..."] or [includeIf "onbranch:..."]
+// sections it contains are resolved against repo, matching what the git
+// command line would report.
+func (r *Repository) Config() (*Config, error) {
+	config := new(Config)
+
+	ret := C.git_repository_config(&config.ptr, r.ptr)
+	if ret < 0 {
+		return nil, LastError()
+	}
+
+	return config, nil
+}